@@ -0,0 +1,48 @@
+package pitr
+
+import "github.com/pingcap/parser/model"
+
+// Callback lets a caller observe DDLHandle's ddl application without forking
+// this package, e.g. to emit progress events to a UI, rewrite ddl text
+// before it runs, or block replay on an unexpected statement.
+type Callback interface {
+	// OnJobRunBefore is called right before a ddl job is applied, by both
+	// ExecuteDDL (wrapping its sql text in a synthetic job) and
+	// AccelerateHistoryDDLs.
+	OnJobRunBefore(job *model.Job)
+	// OnJobRunAfter is called once a job has been applied, with whatever
+	// error (if any) its apply returned.
+	OnJobRunAfter(job *model.Job, err error)
+	// OnSchemaStateChanged is called after a job has successfully mutated
+	// the tracked schema, with its new schema version.
+	OnSchemaStateChanged(schemaVer int64)
+}
+
+// BaseCallback is a Callback whose methods all do nothing; embed it in a
+// Callback implementation to override only the hooks you need.
+type BaseCallback struct{}
+
+// OnJobRunBefore implements Callback.
+func (BaseCallback) OnJobRunBefore(job *model.Job) {}
+
+// OnJobRunAfter implements Callback.
+func (BaseCallback) OnJobRunAfter(job *model.Job, err error) {}
+
+// OnSchemaStateChanged implements Callback.
+func (BaseCallback) OnSchemaStateChanged(schemaVer int64) {}
+
+// Interceptor lets a caller rewrite the *tableInfo GetTableInfo hands out,
+// e.g. to apply per-tenant table renaming before a row event is emitted.
+type Interceptor interface {
+	OnGetTableInfo(schema, table string, info *tableInfo) *tableInfo
+}
+
+// BaseInterceptor is an Interceptor whose OnGetTableInfo returns info
+// unchanged; embed it in an Interceptor implementation to override only the
+// hook you need.
+type BaseInterceptor struct{}
+
+// OnGetTableInfo implements Interceptor.
+func (BaseInterceptor) OnGetTableInfo(schema, table string, info *tableInfo) *tableInfo {
+	return info
+}
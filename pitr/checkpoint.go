@@ -0,0 +1,228 @@
+package pitr
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/parser/model"
+	"go.uber.org/zap"
+)
+
+// checkpointFormatVersion is bumped whenever checkpointData's layout changes
+// incompatibly; RestoreCheckpoint refuses a file stamped with any other
+// version so an old checkpoint triggers a clean full replay instead of
+// loading garbage.
+const checkpointFormatVersion = 1
+
+// checkpointData is what Checkpoint/RestoreCheckpoint persist to disk,
+// borrowing the (job, schema version, schema) triple TiDB's
+// tryLoadSchemaDiffs resumes from.
+type checkpointData struct {
+	Version           int
+	LastJobID         int64
+	LastSchemaVersion int64
+	LastDBInfoMap     map[string]*model.DBInfo
+}
+
+// SetCheckpointPath configures where Checkpoint persists progress and where
+// ExecuteHistoryDDLs looks for a checkpoint to resume from. Must be called
+// before ExecuteHistoryDDLs; an empty path (the default) disables
+// checkpointing entirely.
+func (d *DDLHandle) SetCheckpointPath(path string) {
+	d.checkpointPath = path
+}
+
+// SetMaxNumberOfDiffsToLoad caps how many jobs newer than a restored
+// checkpoint ExecuteHistoryDDLs will replay on top of it before giving up and
+// replaying the whole of historyDDLs instead.
+func (d *DDLHandle) SetMaxNumberOfDiffsToLoad(n int) {
+	d.maxNumberOfDiffsToLoad = n
+}
+
+// SetCheckpointBatchSize configures how many successfully applied jobs
+// advanceCheckpoint lets accumulate before it persists a checkpoint to
+// disk. Must be called before ExecuteHistoryDDLs.
+func (d *DDLHandle) SetCheckpointBatchSize(n int) {
+	d.checkpointBatchSize = n
+}
+
+// Checkpoint persists the tracker's current state to checkpointPath, so a
+// later ExecuteHistoryDDLs can resume from it instead of replaying
+// historyDDLs from scratch. It is a no-op if SetCheckpointPath was never
+// called.
+func (d *DDLHandle) Checkpoint() error {
+	if len(d.checkpointPath) == 0 {
+		return nil
+	}
+
+	d.tracker.RLock()
+	keys := make([]string, 0, len(d.tracker.dbs))
+	srcs := make([]*model.DBInfo, 0, len(d.tracker.dbs))
+	dbLocks := make([]*sync.Mutex, 0, len(d.tracker.dbs))
+	for k, v := range d.tracker.dbs {
+		keys = append(keys, k)
+		srcs = append(srcs, v)
+		dbLocks = append(dbLocks, d.tracker.lockDB(v.Name.O))
+	}
+	d.tracker.RUnlock()
+
+	// AccelerateHistoryDDLs' per-table path mutates a DBInfo's Tables slice
+	// under that database's own dbLock, not the tracker-wide lock Checkpoint
+	// just released above, so take the same dbLock here while copying each
+	// Tables slice - otherwise a concurrent scheduler worker could hand this
+	// marshal a slice that's being appended to/shrunk out from under it.
+	dbs := make(map[string]*model.DBInfo, len(srcs))
+	for i, src := range srcs {
+		dbLocks[i].Lock()
+		snapshot := *src
+		snapshot.Tables = append([]*model.TableInfo(nil), src.Tables...)
+		dbLocks[i].Unlock()
+		dbs[keys[i]] = &snapshot
+	}
+
+	data := checkpointData{
+		Version:           checkpointFormatVersion,
+		LastJobID:         atomic.LoadInt64(&d.checkpointJobID),
+		LastSchemaVersion: atomic.LoadInt64(&d.schemaVer),
+		LastDBInfoMap:     dbs,
+	}
+
+	buf, err := json.Marshal(data)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	// write-then-rename so a crash mid-write can never leave a truncated,
+	// unreadable checkpoint behind.
+	d.checkpointMu.Lock()
+	defer d.checkpointMu.Unlock()
+	tmpPath := d.checkpointPath + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, buf, 0644); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(os.Rename(tmpPath, d.checkpointPath))
+}
+
+// RestoreCheckpoint loads a checkpoint written by Checkpoint from path and
+// applies it directly to the tracker, bypassing ddl replay entirely. It
+// returns an error, without touching the tracker, if path doesn't exist, is
+// corrupt, or was written by an incompatible checkpointFormatVersion.
+func (d *DDLHandle) RestoreCheckpoint(path string) error {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	var data checkpointData
+	if err := json.Unmarshal(buf, &data); err != nil {
+		return errors.Trace(err)
+	}
+	if data.Version != checkpointFormatVersion {
+		return errors.Errorf("checkpoint %s has format version %d, this binary wants %d", path, data.Version, checkpointFormatVersion)
+	}
+
+	d.tracker.Lock()
+	d.tracker.dbs = data.LastDBInfoMap
+	d.tracker.seedNextID()
+	d.tracker.Unlock()
+	atomic.StoreInt64(&d.schemaVer, data.LastSchemaVersion)
+	atomic.StoreInt64(&d.checkpointJobID, data.LastJobID)
+	return nil
+}
+
+// advanceCheckpoint records that job jobID has been applied and, once
+// checkpointBatchSize jobs have accumulated since the last persist, writes
+// the new state out. checkpointJobID only ever moves forward, since
+// AccelerateHistoryDDLs may complete jobs out of their original order under
+// the parallel scheduler; batching the actual writes keeps those workers
+// from serializing on one disk write per job.
+func (d *DDLHandle) advanceCheckpoint(jobID int64) {
+	if len(d.checkpointPath) == 0 {
+		return
+	}
+
+	for {
+		cur := atomic.LoadInt64(&d.checkpointJobID)
+		if jobID <= cur {
+			break
+		}
+		if atomic.CompareAndSwapInt64(&d.checkpointJobID, cur, jobID) {
+			break
+		}
+	}
+
+	batchSize := int64(d.checkpointBatchSize)
+	if batchSize <= 0 {
+		batchSize = defaultCheckpointBatchSize
+	}
+	if atomic.AddInt64(&d.checkpointPending, 1) < batchSize {
+		return
+	}
+	atomic.StoreInt64(&d.checkpointPending, 0)
+
+	if err := d.Checkpoint(); err != nil {
+		log.Warn("failed to persist ddl checkpoint", zap.String("path", d.checkpointPath), zap.Error(err))
+	}
+}
+
+// resumeFromCheckpoint tries to restore checkpointPath and returns the
+// suffix of historyDDLs that still needs replaying. It falls back to
+// replaying every job unchanged when checkpointing is disabled, there's no
+// usable checkpoint, the checkpointed job isn't present in historyDDLs, or
+// the gap between it and the newest job exceeds maxNumberOfDiffsToLoad.
+func (d *DDLHandle) resumeFromCheckpoint(historyDDLs []*model.Job) []*model.Job {
+	if len(d.checkpointPath) == 0 {
+		return historyDDLs
+	}
+
+	if err := d.RestoreCheckpoint(d.checkpointPath); err != nil {
+		log.Info("no usable ddl checkpoint, replaying full history", zap.String("path", d.checkpointPath), zap.Error(err))
+		return historyDDLs
+	}
+	lastJobID := atomic.LoadInt64(&d.checkpointJobID)
+
+	idx := -1
+	for i, job := range historyDDLs {
+		if job.ID == lastJobID {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		log.Warn("ddl checkpoint's job is missing from history, replaying full history", zap.Int64("checkpointJobID", lastJobID))
+		d.discardCheckpoint()
+		return historyDDLs
+	}
+
+	maxDiffs := d.maxNumberOfDiffsToLoad
+	if maxDiffs <= 0 {
+		maxDiffs = defaultMaxNumberOfDiffsToLoad
+	}
+	if gap := len(historyDDLs) - 1 - idx; gap > maxDiffs {
+		log.Warn("ddl checkpoint is too far behind history, replaying full history",
+			zap.Int("gap", gap), zap.Int("maxNumberOfDiffsToLoad", maxDiffs))
+		d.discardCheckpoint()
+		return historyDDLs
+	}
+
+	log.Info("resuming ddl replay from checkpoint", zap.Int64("checkpointJobID", lastJobID), zap.Int("remaining", len(historyDDLs)-idx-1))
+	return historyDDLs[idx+1:]
+}
+
+// discardCheckpoint undoes the tracker/schemaVer/checkpointJobID state
+// RestoreCheckpoint just loaded, for when the checkpoint turns out to be
+// unusable and resumeFromCheckpoint falls back to a full replay: a full
+// replay's CREATE TABLE/DROP TABLE/etc. jobs need a clean, empty schema to
+// run against, not whatever the stale checkpoint left behind.
+func (d *DDLHandle) discardCheckpoint() {
+	d.tracker.Lock()
+	d.tracker.dbs = make(map[string]*model.DBInfo)
+	d.tracker.Unlock()
+	atomic.StoreInt64(&d.schemaVer, 0)
+	atomic.StoreInt64(&d.checkpointJobID, 0)
+}
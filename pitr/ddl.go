@@ -1,31 +1,27 @@
 package pitr
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"os"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/WangXiangUSTC/tidb-lite"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/log"
-	"github.com/pingcap/parser"
 	"github.com/pingcap/parser/ast"
 	"github.com/pingcap/parser/model"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/meta"
 	"go.uber.org/zap"
 )
 
 const (
-	colsSQL = `
-SELECT column_name, extra FROM information_schema.columns
-WHERE table_schema = ? AND table_name = ?;`
-	uniqKeysSQL = `
-SELECT non_unique, index_name, seq_in_index, column_name 
-FROM information_schema.statistics
-WHERE table_schema = ? AND table_name = ?
-ORDER BY seq_in_index ASC;`
 	alldatabases   = `SHOW DATABASES;`
 	alltables      = `SHOW TABLES;`
 	createMapDB    = `CREATE DATABASE _interval_map_;`
@@ -37,35 +33,102 @@ var (
 	// ErrTableNotExist means the table not exist.
 	ErrTableNotExist = errors.New("table not exist")
 
-	// used for run a mock tidb
+	// used for run a mock tidb, only started lazily as a fallback for ddl
+	// the in-memory schema tracker cannot model.
 	defaultTiDBDir  = "/tmp/pitr_tidb"
 	defaultTiDBPort = 40404
+
+	// defaultSchedulerWorkers is how many history ddl jobs AccelerateHistoryDDLs
+	// applies concurrently by default; override with SetSchedulerWorkers.
+	defaultSchedulerWorkers = 4
 )
 
+// defaultMaxNumberOfDiffsToLoad caps how many history jobs newer than a
+// restored checkpoint ExecuteHistoryDDLs will replay on top of it; named
+// after TiDB domain's own MaxNumberOfDiffsToLoad for the same tryLoadSchemaDiffs
+// idea. Beyond this it's cheaper, and safer against a stale/partial
+// checkpoint, to replay from scratch.
+const defaultMaxNumberOfDiffsToLoad = 100
+
+// defaultCheckpointBatchSize caps how many successfully applied jobs
+// advanceCheckpoint lets accumulate before it actually persists a
+// checkpoint to disk. Checkpointing after every single job would serialize
+// every one of the scheduler's parallel workers onto one marshal-and-rename
+// disk write per job, largely negating the scheduler's point for any
+// history of real size.
+const defaultCheckpointBatchSize = 50
+
 // DDLHandle used to handle ddl, and privide the table info
 type DDLHandle struct {
-	db *sql.DB
+	// tracker is the in-memory schema tracker that drives ExecuteDDL /
+	// GetTableInfo in the common case, replacing a live TiDB for metadata.
+	tracker *schemaTracker
 
 	tableInfos sync.Map
 
+	// fallback is a real, embedded TiDB only started on demand, the first
+	// time a ddl uses a feature tracker cannot model.
+	fallback   *sql.DB
 	tidbServer *tidblite.TiDBServer
 
 	historyDDLs []*model.Job
 
-	lastDBInfoMap map[string]*model.DBInfo
-
 	// whether try to accelerate ddl history process.
 	accelerateEnable bool
+
+	// sched parallelizes AccelerateHistoryDDLs across jobs that touch
+	// disjoint tables; schedulerWorkers configures its pool size.
+	sched            *ddlScheduler
+	schedulerWorkers int
+
+	// callback and interceptor let a caller observe or rewrite DDL
+	// application without forking this package; both are nil (disabled) by
+	// default. schemaVer is bumped every time a ddl successfully changes the
+	// tracked schema and handed to callback.OnSchemaStateChanged.
+	callback    Callback
+	interceptor Interceptor
+	schemaVer   int64
+
+	// checkpointPath, when non-empty, is where Checkpoint persists progress
+	// after every checkpointBatchSize history jobs and where
+	// ExecuteHistoryDDLs looks for a checkpoint to resume from, instead of
+	// replaying history from scratch.
+	checkpointPath         string
+	maxNumberOfDiffsToLoad int
+	checkpointJobID        int64
+	checkpointMu           sync.Mutex
+
+	// checkpointBatchSize jobs accumulate in checkpointPending before
+	// advanceCheckpoint actually calls Checkpoint.
+	checkpointBatchSize int
+	checkpointPending   int64
 }
 
 func NewDDLHandle() (*DDLHandle, error) {
-	// run a mock tidb in local, used to execute ddl and get table info
-	if err := os.Mkdir(defaultTiDBDir, os.ModePerm); err != nil {
-		return nil, err
+	ddlHandle := &DDLHandle{
+		tracker:                newSchemaTracker(),
+		accelerateEnable:       true,
+		schedulerWorkers:       defaultSchedulerWorkers,
+		maxNumberOfDiffsToLoad: defaultMaxNumberOfDiffsToLoad,
+		checkpointBatchSize:    defaultCheckpointBatchSize,
+	}
+
+	return ddlHandle, nil
+}
+
+// ensureFallback lazily starts a real, embedded TiDB the first time a ddl
+// can't be modeled by the in-memory tracker.
+func (d *DDLHandle) ensureFallback() error {
+	if d.fallback != nil {
+		return nil
+	}
+
+	if err := os.Mkdir(defaultTiDBDir, os.ModePerm); err != nil && !os.IsExist(err) {
+		return errors.Trace(err)
 	}
 	tidbServer, err := tidblite.NewTiDBServer(tidblite.NewOptions(defaultTiDBDir).WithPort(defaultTiDBPort))
 	if err != nil {
-		return nil, err
+		return errors.Trace(err)
 	}
 
 	var dbConn *sql.DB
@@ -78,20 +141,59 @@ func NewDDLHandle() (*DDLHandle, error) {
 		break
 	}
 	if err != nil {
-		return nil, err
+		return errors.Trace(err)
 	}
 
-	ddlHandle := &DDLHandle{
-		db:               dbConn,
-		tidbServer:       tidbServer,
-		accelerateEnable: true,
-		lastDBInfoMap:    make(map[string]*model.DBInfo),
-	}
+	d.tidbServer = tidbServer
+	d.fallback = dbConn
+	return nil
+}
 
-	return ddlHandle, nil
+// SetCallback registers the Callback invoked around every ddl job applied by
+// ExecuteHistoryDDLs (through AccelerateHistoryDDLs or the serial ExecuteDDL
+// fallback) and around every direct ExecuteDDL call. Pass nil to disable it.
+func (d *DDLHandle) SetCallback(cb Callback) {
+	d.callback = cb
 }
 
+// SetInterceptor registers the Interceptor given a chance to rewrite every
+// *tableInfo GetTableInfo hands out. Pass nil to disable it.
+func (d *DDLHandle) SetInterceptor(ic Interceptor) {
+	d.interceptor = ic
+}
+
+// ExecuteHistoryDDLs replays historyDDLs in order, either through the
+// scheduler (AccelerateHistoryDDLs) or serially (ExecuteDDL) depending on
+// accelerateEnable; either path invokes callback once per job. If
+// SetCheckpointPath has been called, it first tries to resume from the
+// checkpoint written by an earlier run, replaying only the jobs after it.
 func (d *DDLHandle) ExecuteHistoryDDLs(historyDDLs []*model.Job) error {
+	historyDDLs = d.resumeFromCheckpoint(historyDDLs)
+
+	var err error
+	if d.accelerateEnable {
+		err = d.scheduler().run(historyDDLs, skipJob, d.AccelerateHistoryDDLs)
+	} else {
+		err = d.executeHistoryDDLsSerially(historyDDLs)
+	}
+	if err == nil {
+		// advanceCheckpoint only persists every checkpointBatchSize jobs;
+		// flush whatever's left so a finished run is never behind its own
+		// progress on disk.
+		if cerr := d.Checkpoint(); cerr != nil {
+			log.Warn("failed to persist final ddl checkpoint", zap.String("path", d.checkpointPath), zap.Error(cerr))
+		}
+	}
+	return err
+}
+
+// executeHistoryDDLsSerially is the accelerateEnable=false fallback path for
+// ExecuteHistoryDDLs: it applies historyDDLs one job at a time through
+// ExecuteDDL instead of the scheduler, advancing the checkpoint after every
+// job the same way AccelerateHistoryDDLs does - without this, a caller that
+// configures a checkpointPath but disables acceleration would silently stop
+// getting checkpoints written at all.
+func (d *DDLHandle) executeHistoryDDLsSerially(historyDDLs []*model.Job) error {
 	for _, ddl := range historyDDLs {
 		if skipJob(ddl) {
 			continue
@@ -101,20 +203,45 @@ func (d *DDLHandle) ExecuteHistoryDDLs(historyDDLs []*model.Job) error {
 		if ddl.BinlogInfo != nil && ddl.BinlogInfo.DBInfo != nil {
 			schemaName = ddl.BinlogInfo.DBInfo.Name.O
 		}
-		if d.accelerateEnable {
-			if err := d.AccelerateHistoryDDLs(ddl); err != nil {
-				return errors.Trace(err)
-			}
-		} else {
-			if err := d.ExecuteDDL(schemaName, ddl.Query); err != nil {
-				return errors.Trace(err)
-			}
+		if err := d.ExecuteDDL(schemaName, ddl.Query); err != nil {
+			return errors.Trace(err)
 		}
+		d.advanceCheckpoint(ddl.ID)
 	}
 
 	return nil
 }
 
+// scheduler lazily builds the dependency scheduler used to parallelize
+// AccelerateHistoryDDLs, sized by schedulerWorkers (defaultSchedulerWorkers
+// unless SetSchedulerWorkers changed it; a value <= 0 means 1, i.e. serial).
+func (d *DDLHandle) scheduler() *ddlScheduler {
+	if d.sched == nil {
+		workers := d.schedulerWorkers
+		if workers <= 0 {
+			workers = 1
+		}
+		d.sched = newDDLScheduler(workers)
+	}
+	return d.sched
+}
+
+// SetSchedulerWorkers configures how many history ddl jobs AccelerateHistoryDDLs
+// may apply concurrently. Must be called before ExecuteHistoryDDLs; it has
+// no effect once the scheduler has been built.
+func (d *DDLHandle) SetSchedulerWorkers(n int) {
+	d.schedulerWorkers = n
+}
+
+// SchedulerStats reports the current queue depth and the highest parallelism
+// the scheduler has reached so far, for monitoring PITR startup.
+func (d *DDLHandle) SchedulerStats() (queueDepth, maxParallelism int64) {
+	if d.sched == nil {
+		return 0, 0
+	}
+	return d.sched.QueueDepth(), d.sched.MaxParallelism()
+}
+
 /*
  * Scan the ddl history job slice, record the last state & tableInfo for every tableInfo.
  * Example:
@@ -127,28 +254,74 @@ func (d *DDLHandle) ExecuteHistoryDDLs(historyDDLs []*model.Job) error {
  * Every ddl job will record the final state and tableInfo after executed.
  */
 func (d *DDLHandle) AccelerateHistoryDDLs(job *model.Job) error {
+	if d.callback != nil {
+		d.callback.OnJobRunBefore(job)
+	}
+	err := d.applyAccelerateJob(job)
+	var schemaVer int64
+	if err == nil {
+		schemaVer = atomic.AddInt64(&d.schemaVer, 1)
+	}
+	if d.callback != nil {
+		d.callback.OnJobRunAfter(job, err)
+		if err == nil {
+			d.callback.OnSchemaStateChanged(schemaVer)
+		}
+	}
+	if err == nil {
+		d.advanceCheckpoint(job.ID)
+	}
+	return err
+}
+
+// applyAccelerateJob holds the actual job-dispatch logic for AccelerateHistoryDDLs,
+// kept separate so the callback invocations above wrap every return path once.
+func (d *DDLHandle) applyAccelerateJob(job *model.Job) error {
 	switch job.Type {
 	case model.ActionCreateSchema, model.ActionModifySchemaCharsetAndCollate, model.ActionDropSchema:
+		// schema-wide jobs mutate the dbs map itself (add/remove a key), so
+		// they need the tracker-wide lock, not just a per-db one.
+		d.tracker.Lock()
+		defer d.tracker.Unlock()
+
 		if job.BinlogInfo.DBInfo.State == model.StatePublic {
 			// Take if not exists into consideration, we will override there.
-			d.lastDBInfoMap[quoteDB(job.BinlogInfo.DBInfo.Name.L)] = job.BinlogInfo.DBInfo
+			d.tracker.dbs[quoteDB(job.BinlogInfo.DBInfo.Name.L)] = job.BinlogInfo.DBInfo
 		}
 		if job.BinlogInfo.DBInfo.State == model.StateNone {
-			delete(d.lastDBInfoMap, quoteDB(job.BinlogInfo.DBInfo.Name.L))
+			delete(d.tracker.dbs, quoteDB(job.BinlogInfo.DBInfo.Name.L))
 		}
 		return nil
+	case model.ActionRenameTable, model.ActionRenameTables:
+		// a rename can move a table into a different database, and the
+		// atomic multi-table form (`rename table a to b, c to d`, job type
+		// ActionRenameTables) touches several tables - each potentially
+		// moving to its own database - in one job, so it needs its own
+		// handling rather than the single-db, single-table path below.
+		return d.accelerateRenameTable(job)
 	case model.ActionCreateTable, model.ActionCreateView, model.ActionDropTable, model.ActionDropView,
 		model.ActionDropTablePartition, model.ActionTruncateTablePartition, model.ActionAddColumn,
 		model.ActionDropColumn, model.ActionModifyColumn, model.ActionSetDefaultValue, model.ActionAddIndex,
 		model.ActionDropIndex, model.ActionRenameIndex, model.ActionAddForeignKey, model.ActionDropForeignKey,
-		model.ActionTruncateTable, model.ActionRebaseAutoID, model.ActionRenameTable, model.ActionShardRowID,
+		model.ActionTruncateTable, model.ActionRebaseAutoID, model.ActionShardRowID,
 		model.ActionModifyTableComment, model.ActionAddTablePartition, model.ActionModifyTableCharsetAndCollate,
 		model.ActionRecoverTable:
+		// only read the dbs map here (RLock), then serialize on the affected
+		// database's own lock so unrelated databases can be updated by other
+		// workers at the same time.
+		d.tracker.RLock()
+		v, ok := d.tracker.dbs[quoteDB(strings.ToLower(job.SchemaName))]
+		if !ok {
+			d.tracker.RUnlock()
+			return errors.New(fmt.Sprintf("database %s haven't exist in ddl history before use it", job.SchemaName))
+		}
+		dbLock := d.tracker.lockDB(job.SchemaName)
+		d.tracker.RUnlock()
+
+		dbLock.Lock()
+		defer dbLock.Unlock()
+
 		if job.BinlogInfo.TableInfo.State == model.StatePublic {
-			v, ok := d.lastDBInfoMap[quoteDB(strings.ToLower(job.SchemaName))]
-			if !ok {
-				return errors.New(fmt.Sprintf("database %s haven't exist in ddl history before use it", job.SchemaName))
-			}
 			// substitute the latest tableInfo for the old one in lastDBInfoMap.
 			newTableInfo := job.BinlogInfo.TableInfo
 			for i, t := range v.Tables {
@@ -161,10 +334,6 @@ func (d *DDLHandle) AccelerateHistoryDDLs(job *model.Job) error {
 			v.Tables = append(v.Tables, newTableInfo)
 		} else if job.BinlogInfo.TableInfo.State == model.StateNone {
 			// stateNone means the table has been dropped, remove it in lastDBInfoMap.
-			v, ok := d.lastDBInfoMap[quoteDB(strings.ToLower(job.SchemaName))]
-			if !ok {
-				return errors.New(fmt.Sprintf("database %s haven't exist in ddl history before use it", job.SchemaName))
-			}
 			stateNoneTableInfo := job.BinlogInfo.TableInfo
 			for i, t := range v.Tables {
 				if t.ID == stateNoneTableInfo.ID {
@@ -182,6 +351,173 @@ func (d *DDLHandle) AccelerateHistoryDDLs(job *model.Job) error {
 	return nil
 }
 
+// accelerateRenameTable applies a rename-table job to lastDBInfoMap, for
+// both the single-table form (ActionRenameTable) and the atomic multi-table
+// form (ActionRenameTables, `rename table a to b, c to d`), each of which
+// can independently move its table into a different database than it
+// started in.
+//
+// model.HistoryInfo (BinlogInfo) only ever carries the one TableInfo
+// FinishTableJob was called with - the last table the job touched - never a
+// list of every table a multi-table rename affected; see TiDB's
+// onRenameTables/checkAndRenameTables, which loops calling checkAndRenameTables
+// per table and only hands FinishTableJob the final tblInfo. So every table
+// renamed, and which schema it moved from/to, is decoded straight out of
+// job.Args instead.
+//
+// Like the single-table path in applyAccelerateJob, this only holds the
+// tracker-wide lock long enough to resolve the schemas a rename touches,
+// then mutates their Tables slices under those databases' own dbLocks -
+// never the tracker-wide lock - so it can't deadlock or data-race with a
+// same-schema job running concurrently under the scheduler's non-barrier
+// same-schema-rename dependency key. Every dbLock involved is always
+// acquired in the same (sorted by schema name) order, so two renames
+// touching the same pair of schemas from opposite directions can never
+// deadlock on each other either.
+func (d *DDLHandle) accelerateRenameTable(job *model.Job) error {
+	renames, err := decodeRenameTables(job)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	d.tracker.RLock()
+	dbByID := make(map[int64]*model.DBInfo)
+	for _, r := range renames {
+		for _, id := range [2]int64{r.oldSchemaID, r.newSchemaID} {
+			if _, ok := dbByID[id]; ok {
+				continue
+			}
+			db, ok := d.tracker.findDBByID(id)
+			if !ok {
+				d.tracker.RUnlock()
+				return errors.Errorf("database id %d haven't exist in ddl history before use it", id)
+			}
+			dbByID[id] = db
+		}
+	}
+	touchedDBs := make([]*model.DBInfo, 0, len(dbByID))
+	for _, db := range dbByID {
+		touchedDBs = append(touchedDBs, db)
+	}
+	sort.Slice(touchedDBs, func(i, j int) bool { return touchedDBs[i].Name.L < touchedDBs[j].Name.L })
+	dbLocks := make([]*sync.Mutex, len(touchedDBs))
+	for i, db := range touchedDBs {
+		dbLocks[i] = d.tracker.lockDB(db.Name.O)
+	}
+	d.tracker.RUnlock()
+
+	for _, l := range dbLocks {
+		l.Lock()
+	}
+	defer func() {
+		for _, l := range dbLocks {
+			l.Unlock()
+		}
+	}()
+
+	lastTableInfo := job.BinlogInfo.TableInfo
+
+	for _, r := range renames {
+		oldDB, ok := d.tracker.findDBByID(r.oldSchemaID)
+		if !ok {
+			return errors.Errorf("database id %d haven't exist in ddl history before use it", r.oldSchemaID)
+		}
+		newDB, ok := d.tracker.findDBByID(r.newSchemaID)
+		if !ok {
+			return errors.Errorf("database id %d haven't exist in ddl history before use it", r.newSchemaID)
+		}
+
+		var table *model.TableInfo
+		if lastTableInfo != nil && lastTableInfo.ID == r.tableID {
+			// the one table BinlogInfo carries the full post-rename state for.
+			table = lastTableInfo
+		} else {
+			// every other table in a multi-table rename: BinlogInfo doesn't
+			// carry its post-rename TableInfo, so rename the tracked copy in
+			// place instead.
+			for _, t := range oldDB.Tables {
+				if t.ID == r.tableID {
+					table = t
+					break
+				}
+			}
+			if table == nil {
+				return errors.Errorf("table id %d haven't exist in ddl history before rename it", r.tableID)
+			}
+			table.Name = r.newName
+		}
+
+		for i, t := range oldDB.Tables {
+			if t.ID == r.tableID {
+				oldDB.Tables = append(oldDB.Tables[:i], oldDB.Tables[i+1:]...)
+				break
+			}
+		}
+		for i, t := range newDB.Tables {
+			if t.ID == r.tableID {
+				newDB.Tables[i] = table
+				table = nil
+				break
+			}
+		}
+		if table != nil {
+			newDB.Tables = append(newDB.Tables, table)
+		}
+	}
+
+	return nil
+}
+
+// renamedTable is one (oldSchemaID, newSchemaID, tableID, newName) tuple
+// decoded from a rename job's Args.
+type renamedTable struct {
+	oldSchemaID int64
+	newSchemaID int64
+	tableID     int64
+	newName     model.CIStr
+}
+
+// decodeRenameTables decodes the renamedTable(s) a rename job touched out of
+// job.Args, mirroring onRenameTable/onRenameTables in TiDB's ddl package:
+// ActionRenameTable's args are (oldSchemaID, tableName), with the new schema
+// id on job.SchemaID and the table id on job.TableID; ActionRenameTables'
+// args are the parallel (oldSchemaIDs, newSchemaIDs, tableNames, tableIDs)
+// slices for every table the statement touched.
+func decodeRenameTables(job *model.Job) ([]renamedTable, error) {
+	switch job.Type {
+	case model.ActionRenameTable:
+		var oldSchemaID int64
+		var tableName model.CIStr
+		if err := job.DecodeArgs(&oldSchemaID, &tableName); err != nil {
+			return nil, errors.Trace(err)
+		}
+		return []renamedTable{{
+			oldSchemaID: oldSchemaID,
+			newSchemaID: job.SchemaID,
+			tableID:     job.TableID,
+			newName:     tableName,
+		}}, nil
+	case model.ActionRenameTables:
+		var oldSchemaIDs, newSchemaIDs, tableIDs []int64
+		var tableNames []*model.CIStr
+		if err := job.DecodeArgs(&oldSchemaIDs, &newSchemaIDs, &tableNames, &tableIDs); err != nil {
+			return nil, errors.Trace(err)
+		}
+		renames := make([]renamedTable, 0, len(oldSchemaIDs))
+		for i := range oldSchemaIDs {
+			renames = append(renames, renamedTable{
+				oldSchemaID: oldSchemaIDs[i],
+				newSchemaID: newSchemaIDs[i],
+				tableID:     tableIDs[i],
+				newName:     *tableNames[i],
+			})
+		}
+		return renames, nil
+	default:
+		return nil, errors.Errorf("unexpected ddl action type %s for rename table", job.Type.String())
+	}
+}
+
 // ExecuteDDL executes ddl, and then update the table's info
 func (d *DDLHandle) ExecuteDDL(schema string, ddl string) error {
 	log.Info("execute ddl", zap.String("ddl", ddl))
@@ -189,26 +525,77 @@ func (d *DDLHandle) ExecuteDDL(schema string, ddl string) error {
 	if len(ddl) == 0 {
 		return nil
 	}
-	schemaInDDL, table, err := parserSchemaTableFromDDL(ddl)
-	if err != nil {
+
+	// ExecuteDDL has no *model.Job of its own (it's driven straight off sql
+	// text), so wrap the query in a synthetic one purely to give Callback a
+	// uniform shape to look at, same as the jobs AccelerateHistoryDDLs sees.
+	job := &model.Job{SchemaName: schema, Query: ddl}
+	if d.callback != nil {
+		d.callback.OnJobRunBefore(job)
+	}
+	err := d.applyExecuteDDL(schema, ddl)
+	var schemaVer int64
+	if err == nil {
+		schemaVer = atomic.AddInt64(&d.schemaVer, 1)
+	}
+	if d.callback != nil {
+		d.callback.OnJobRunAfter(job, err)
+		if err == nil {
+			d.callback.OnSchemaStateChanged(schemaVer)
+		}
+	}
+	return err
+}
+
+func (d *DDLHandle) applyExecuteDDL(schema string, ddl string) error {
+	affected, err := d.tracker.applyDDL(schema, ddl)
+	if err == nil {
+		for _, t := range affected {
+			table, ok := d.tracker.getTable(t.schema, t.table)
+			if !ok {
+				// table was dropped/renamed away, drop any cached info too.
+				d.tableInfos.Delete(quoteSchema(t.schema, t.table))
+				continue
+			}
+			d.tableInfos.Store(quoteSchema(t.schema, t.table), deriveTableInfo(t.schema, table))
+		}
+		return nil
+	}
+	if errors.Cause(err) != errUnsupportedDDL {
 		return errors.Trace(err)
 	}
 
-	if len(schema) == 0 {
+	log.Warn("ddl not supported by schema tracker, fall back to embedded tidb", zap.String("ddl", ddl))
+	return d.executeDDLOnFallback(schema, ddl)
+}
+
+// executeDDLOnFallback runs ddl against a real, embedded TiDB for the rare
+// statements the in-memory tracker cannot model, and re-derives the table's
+// info from its information_schema afterwards.
+func (d *DDLHandle) executeDDLOnFallback(schema string, ddl string) error {
+	if err := d.ensureFallback(); err != nil {
+		return errors.Trace(err)
+	}
+
+	schemaInDDL, tables, err := parserSchemaTableFromDDL(ddl)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(schemaInDDL) != 0 {
 		schema = schemaInDDL
 	}
 
-	if _, err := d.db.Exec(ddl); err != nil {
+	if _, err := d.fallback.Exec(ddl); err != nil {
 		if strings.Contains(err.Error(), "Unknown database") {
-			err := d.ExecuteDDL(schema, fmt.Sprintf("create database if not exists `%s`", schema))
+			err := d.executeDDLOnFallback(schema, fmt.Sprintf("create database if not exists `%s`", schema))
 			if err != nil {
 				return errors.Trace(err)
 			}
 
-			return d.ExecuteDDL(schema, ddl)
+			return d.executeDDLOnFallback(schema, ddl)
 		} else if strings.Contains(err.Error(), "No database selected") {
 			if len(schema) != 0 {
-				return d.ExecuteDDL(schema, fmt.Sprintf("use %s; %s", schema, ddl))
+				return d.executeDDLOnFallback(schema, fmt.Sprintf("use %s; %s", schema, ddl))
 			}
 		} else if strings.Contains(err.Error(), "already exists") {
 			return nil
@@ -217,33 +604,57 @@ func (d *DDLHandle) ExecuteDDL(schema string, ddl string) error {
 		return errors.Trace(err)
 	}
 
-	info, err := getTableInfo(d.db, schema, table)
-	if err != nil {
-		// ddl drop table
-		if err == ErrTableNotExist {
-			return nil
+	for _, table := range tables {
+		info, err := getTableInfo(d.fallback, schema, table)
+		if err != nil {
+			// ddl drop table
+			if err == ErrTableNotExist {
+				d.tableInfos.Delete(quoteSchema(schema, table))
+				continue
+			}
+			return errors.Trace(err)
 		}
-		return errors.Trace(err)
+		d.tableInfos.Store(quoteSchema(schema, table), info)
 	}
-	d.tableInfos.Store(quoteSchema(schema, table), info)
 
 	return nil
 }
 
 // GetTableInfo get table's info
 func (d *DDLHandle) GetTableInfo(schema, table string) (*tableInfo, error) {
+	info, err := d.lookupTableInfo(schema, table)
+	if err != nil {
+		return nil, err
+	}
+	if d.interceptor != nil {
+		info = d.interceptor.OnGetTableInfo(schema, table, info)
+	}
+	return info, nil
+}
+
+func (d *DDLHandle) lookupTableInfo(schema, table string) (*tableInfo, error) {
 	v, ok := d.tableInfos.Load(quoteSchema(schema, table))
 	if ok {
 		info := v.(*tableInfo)
 		return info, nil
 	}
-	log.Warn("table info not in memory, will get from local tidb")
 
-	return getTableInfo(d.db, schema, table)
+	d.tracker.RLock()
+	ti, ok := d.tracker.getTable(schema, table)
+	d.tracker.RUnlock()
+	if ok {
+		return deriveTableInfo(schema, ti), nil
+	}
+
+	if d.fallback == nil {
+		return nil, ErrTableNotExist
+	}
+	log.Warn("table info not in memory, will get from fallback tidb")
+	return getTableInfo(d.fallback, schema, table)
 }
 
 func (d *DDLHandle) getAllDatabaseNames() ([]string, error) {
-	rows, err := d.db.Query(alldatabases)
+	rows, err := d.fallback.Query(alldatabases)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
@@ -266,6 +677,15 @@ func (d *DDLHandle) getAllDatabaseNames() ([]string, error) {
 }
 
 func (d *DDLHandle) ResetDB() error {
+	d.tracker.Lock()
+	d.tracker.dbs = make(map[string]*model.DBInfo)
+	d.tracker.Unlock()
+	d.tableInfos = sync.Map{}
+
+	if d.fallback == nil {
+		return d.ExecuteDDL("test", "CREATE DATABASE IF NOT EXISTS test")
+	}
+
 	names, err := d.getAllDatabaseNames()
 	if err != nil {
 		return err
@@ -273,7 +693,7 @@ func (d *DDLHandle) ResetDB() error {
 	var sql string
 	for _, v := range names {
 		sql = fmt.Sprintf("DROP DATABASE %s", v)
-		err = d.ExecuteDDL(v, sql)
+		err = d.executeDDLOnFallback(v, sql)
 		if err != nil {
 			return err
 		}
@@ -284,6 +704,9 @@ func (d *DDLHandle) ResetDB() error {
 }
 
 func (d *DDLHandle) Close() {
+	if d.tidbServer == nil {
+		return
+	}
 	d.tidbServer.Close()
 
 	if err := os.RemoveAll(defaultTiDBDir); err != nil {
@@ -306,8 +729,48 @@ type indexInfo struct {
 	columns []string
 }
 
+// deriveTableInfo builds the column/unique-key summary ExecuteDDL and
+// GetTableInfo hand out, straight from a tracked *model.TableInfo, instead
+// of round-tripping through information_schema.
+func deriveTableInfo(schema string, ti *model.TableInfo) *tableInfo {
+	info := &tableInfo{
+		schema: schema,
+		table:  ti.Name.O,
+	}
+
+	for _, col := range ti.Columns {
+		if col.GeneratedExprString != "" {
+			continue
+		}
+		info.columns = append(info.columns, col.Name.O)
+	}
+
+	for _, idx := range ti.Indices {
+		if !idx.Unique {
+			continue
+		}
+		cols := make([]string, 0, len(idx.Columns))
+		for _, c := range idx.Columns {
+			cols = append(cols, c.Name.O)
+		}
+		info.uniqueKeys = append(info.uniqueKeys, indexInfo{name: idx.Name.O, columns: cols})
+	}
+
+	// put primary key at first place, and set primaryKey
+	for i := 0; i < len(info.uniqueKeys); i++ {
+		if strings.EqualFold(info.uniqueKeys[i].name, "PRIMARY") {
+			info.uniqueKeys[i], info.uniqueKeys[0] = info.uniqueKeys[0], info.uniqueKeys[i]
+			info.primaryKey = &info.uniqueKeys[0]
+			break
+		}
+	}
+
+	return info
+}
+
 // getTableInfo returns information like (non-generated) column names and
-// unique keys about the specified table
+// unique keys about the specified table, by querying a live TiDB. Only used
+// by the embedded-TiDB fallback path.
 func getTableInfo(db *sql.DB, schema string, table string) (info *tableInfo, err error) {
 	info = &tableInfo{
 		schema: schema,
@@ -335,6 +798,17 @@ func getTableInfo(db *sql.DB, schema string, table string) (info *tableInfo, err
 	return
 }
 
+const (
+	colsSQL = `
+SELECT column_name, extra FROM information_schema.columns
+WHERE table_schema = ? AND table_name = ?;`
+	uniqKeysSQL = `
+SELECT non_unique, index_name, seq_in_index, column_name
+FROM information_schema.statistics
+WHERE table_schema = ? AND table_name = ?
+ORDER BY seq_in_index ASC;`
+)
+
 // getColsOfTbl returns a slice of the names of all columns,
 // generated columns are excluded.
 // https://dev.mysql.com/doc/mysql-infoschema-excerpt/5.7/en/columns-table.html
@@ -419,15 +893,20 @@ func getUniqKeys(db *sql.DB, schema, table string) (uniqueKeys []indexInfo, err
 	return
 }
 
-// parserSchemaTableFromDDL parses ddl query to get schema and table
-// ddl like `use test; create table`
-func parserSchemaTableFromDDL(ddlQuery string) (schema, table string, err error) {
-	stmts, _, err := parser.New().Parse(ddlQuery, "", "")
+// parserSchemaTableFromDDL parses ddl query to get schema and the table(s) it
+// touches - ddl like `use test; create table`. A DropTableStmt or a
+// RenameTableStmt may each name more than one table (`drop table a, b`,
+// `rename table a to b, c to d`), so every table they name is returned; a
+// renamed table is returned under its new name only, and schema is the last
+// schema the statement names.
+func parserSchemaTableFromDDL(ddlQuery string) (schema string, tables []string, err error) {
+	stmts, err := parseStmts(ddlQuery)
 	if err != nil {
-		return "", "", err
+		return "", nil, err
 	}
 
 	haveUseStmt := false
+	var table string
 
 	for _, stmt := range stmts {
 		switch node := stmt.(type) {
@@ -439,62 +918,57 @@ func parserSchemaTableFromDDL(ddlQuery string) (schema, table string, err error)
 		case *ast.DropDatabaseStmt:
 			schema = node.Name
 		case *ast.TruncateTableStmt:
-			if len(node.Table.Schema.O) != 0 {
-				schema = node.Table.Schema.O
-			}
-			table = node.Table.Name.O
+			schema, table = schemaOf(node.Table, schema), node.Table.Name.O
+			tables = []string{table}
 		case *ast.CreateIndexStmt:
-			if len(node.Table.Schema.O) != 0 {
-				schema = node.Table.Schema.O
-			}
-			table = node.Table.Name.O
+			schema, table = schemaOf(node.Table, schema), node.Table.Name.O
+			tables = []string{table}
 		case *ast.CreateTableStmt:
-			if len(node.Table.Schema.O) != 0 {
-				schema = node.Table.Schema.O
-			}
-			table = node.Table.Name.O
+			schema, table = schemaOf(node.Table, schema), node.Table.Name.O
+			tables = []string{table}
 		case *ast.DropIndexStmt:
-			if len(node.Table.Schema.O) != 0 {
-				schema = node.Table.Schema.O
-			}
-			table = node.Table.Name.O
+			schema, table = schemaOf(node.Table, schema), node.Table.Name.O
+			tables = []string{table}
 		case *ast.AlterTableStmt:
-			if len(node.Table.Schema.O) != 0 {
-				schema = node.Table.Schema.O
-			}
-			table = node.Table.Name.O
+			schema, table = schemaOf(node.Table, schema), node.Table.Name.O
+			tables = []string{table}
 		case *ast.DropTableStmt:
-			// FIXME: may drop more than one table in a ddl
-			if len(node.Tables[0].Schema.O) != 0 {
-				schema = node.Tables[0].Schema.O
+			tables = tables[:0]
+			for _, tn := range node.Tables {
+				schema, table = schemaOf(tn, schema), tn.Name.O
+				tables = append(tables, table)
 			}
-			table = node.Tables[0].Name.O
 		case *ast.RenameTableStmt:
-			if len(node.NewTable.Schema.O) != 0 {
-				schema = node.NewTable.Schema.O
+			pairs := node.TableToTables
+			if len(pairs) == 0 {
+				pairs = []*ast.TableToTable{{OldTable: node.OldTable, NewTable: node.NewTable}}
+			}
+			tables = tables[:0]
+			for _, pair := range pairs {
+				schema, table = schemaOf(pair.NewTable, schema), pair.NewTable.Name.O
+				tables = append(tables, table)
 			}
-			table = node.NewTable.Name.O
 		default:
-			return "", "", errors.Errorf("unknown ddl type, ddl: %s", ddlQuery)
+			return "", nil, errors.Errorf("unknown ddl type, ddl: %s", ddlQuery)
 		}
 	}
 
 	if haveUseStmt {
 		if len(stmts) != 2 {
-			return "", "", errors.Errorf("invalid ddl %s", ddlQuery)
+			return "", nil, errors.Errorf("invalid ddl %s", ddlQuery)
 		}
 	} else {
 		if len(stmts) != 1 {
-			return "", "", errors.Errorf("invalid ddl %s", ddlQuery)
+			return "", nil, errors.Errorf("invalid ddl %s", ddlQuery)
 		}
 	}
 
-	return
+	return schema, tables, nil
 }
 
 func (d *DDLHandle) getAllTableNames(schema string) ([]string, error) {
 	udb := fmt.Sprintf("USE %s;", schema)
-	rows, err := d.db.Query(udb + alltables)
+	rows, err := d.fallback.Query(udb + alltables)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
@@ -522,7 +996,7 @@ func (d *DDLHandle) createMapTable() error {
 
 func (d *DDLHandle) fetchMapKeyFromDB(key string) (string, error) {
 	sel := fmt.Sprintf(`SELECT srcKey FROM _inter_map_ WHERE curKey = '%s'`, key)
-	rows, err := d.db.Query(useIntervalDB + sel)
+	rows, err := d.fallback.Query(useIntervalDB + sel)
 	if err != nil {
 		return "", errors.Trace(err)
 	}
@@ -549,7 +1023,7 @@ func (d *DDLHandle) insertMapKeyFromDB(newKey, oldKey string) error {
 	} else {
 		ins = fmt.Sprintf(`INSERT INTO _interval_map_._inter_map_ VALUES ('%s', '%s')`, newKey, oldKey)
 	}
-	_, err = d.db.Exec(ins)
+	_, err = d.fallback.Exec(ins)
 	return err
 }
 
@@ -561,17 +1035,50 @@ func skipJob(job *model.Job) bool {
 	return !job.IsSynced() && !job.IsDone()
 }
 
-func (d *DDLHandle) ShiftMetaToTiDB() error {
-	var DBInfos []*model.DBInfo
-	for _, v := range d.lastDBInfoMap {
+// ShiftMetaToTiDB pushes the tracked schema into the embedded TiDB for a
+// BR-style ingest. DBInfo/TableInfo ids that came from the upstream cluster
+// would otherwise collide with whatever the local TiDB allocates next, so
+// every id is first preallocated from the local TiDB's own id space; it
+// returns the resulting old id -> new id map so callers can translate table
+// ids in the row-DML stream they replay on top of this schema.
+func (d *DDLHandle) ShiftMetaToTiDB() (map[int64]int64, error) {
+	if err := d.ensureFallback(); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	d.tracker.Lock()
+	defer d.tracker.Unlock()
+
+	DBInfos := make([]*model.DBInfo, 0, len(d.tracker.dbs))
+	for _, v := range d.tracker.dbs {
 		DBInfos = append(DBInfos, v)
 	}
-	return d.tidbServer.SetDBInfoMetaAndReload(DBInfos)
+
+	// GenGlobalIDs reserves ids out of the local TiDB's own meta, so it has
+	// to run against a meta.Meta bound to a real txn on its storage, not the
+	// *tidblite.TiDBServer itself.
+	var idMap map[int64]int64
+	err := kv.RunInNewTxn(context.Background(), d.tidbServer.GetStorage(), true, func(ctx context.Context, txn kv.Transaction) error {
+		var err error
+		idMap, err = preallocIDs(meta.NewMeta(txn), DBInfos)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	if err := d.tidbServer.SetDBInfoMetaAndReload(DBInfos); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return idMap, nil
 }
 
 func (d *DDLHandle) SetServerHistoryAccelerate(server *tidblite.TiDBServer, jobs []*model.Job, m map[string]*model.DBInfo, ac bool) {
 	d.tidbServer = server
 	d.historyDDLs = jobs
-	d.lastDBInfoMap = m
+	d.tracker.Lock()
+	d.tracker.dbs = m
+	d.tracker.seedNextID()
+	d.tracker.Unlock()
 	d.accelerateEnable = ac
 }
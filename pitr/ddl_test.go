@@ -0,0 +1,103 @@
+package pitr
+
+import (
+	"testing"
+
+	"github.com/pingcap/parser/model"
+)
+
+func TestDecodeRenameTablesSingle(t *testing.T) {
+	job := &model.Job{
+		Type:     model.ActionRenameTable,
+		SchemaID: 2,
+		TableID:  10,
+		Args:     []interface{}{int64(1), model.NewCIStr("new_t1")},
+	}
+	if _, err := job.Encode(true); err != nil {
+		t.Fatalf("job.Encode: %v", err)
+	}
+
+	renames, err := decodeRenameTables(job)
+	if err != nil {
+		t.Fatalf("decodeRenameTables returned error: %v", err)
+	}
+	if len(renames) != 1 {
+		t.Fatalf("expected 1 renamedTable, got %d", len(renames))
+	}
+
+	got := renames[0]
+	want := renamedTable{oldSchemaID: 1, newSchemaID: 2, tableID: 10, newName: model.NewCIStr("new_t1")}
+	if got != want {
+		t.Errorf("decodeRenameTables = %+v, want %+v", got, want)
+	}
+}
+
+func TestDecodeRenameTablesMulti(t *testing.T) {
+	t1, t2 := model.NewCIStr("new_t1"), model.NewCIStr("new_t2")
+	job := &model.Job{
+		Type: model.ActionRenameTables,
+		Args: []interface{}{
+			[]int64{1, 2},
+			[]int64{3, 4},
+			[]*model.CIStr{&t1, &t2},
+			[]int64{10, 20},
+		},
+	}
+	if _, err := job.Encode(true); err != nil {
+		t.Fatalf("job.Encode: %v", err)
+	}
+
+	renames, err := decodeRenameTables(job)
+	if err != nil {
+		t.Fatalf("decodeRenameTables returned error: %v", err)
+	}
+
+	want := []renamedTable{
+		{oldSchemaID: 1, newSchemaID: 3, tableID: 10, newName: t1},
+		{oldSchemaID: 2, newSchemaID: 4, tableID: 20, newName: t2},
+	}
+	if len(renames) != len(want) {
+		t.Fatalf("expected %d renamedTables, got %d", len(want), len(renames))
+	}
+	for i := range want {
+		if renames[i] != want[i] {
+			t.Errorf("renames[%d] = %+v, want %+v", i, renames[i], want[i])
+		}
+	}
+}
+
+func TestAccelerateRenameTableCrossSchema(t *testing.T) {
+	d := &DDLHandle{tracker: newSchemaTracker()}
+	d.tracker.dbs["`a`"] = &model.DBInfo{
+		ID:   1,
+		Name: model.NewCIStr("a"),
+		Tables: []*model.TableInfo{
+			{ID: 10, Name: model.NewCIStr("t1")},
+		},
+	}
+	d.tracker.dbs["`b`"] = &model.DBInfo{ID: 2, Name: model.NewCIStr("b")}
+
+	job := &model.Job{
+		Type:       model.ActionRenameTable,
+		SchemaID:   2,
+		SchemaName: "b",
+		TableID:    10,
+		BinlogInfo: &model.HistoryInfo{TableInfo: &model.TableInfo{ID: 10, Name: model.NewCIStr("t2")}},
+		Args:       []interface{}{int64(1), model.NewCIStr("t2")},
+	}
+	if _, err := job.Encode(true); err != nil {
+		t.Fatalf("job.Encode: %v", err)
+	}
+
+	if err := d.accelerateRenameTable(job); err != nil {
+		t.Fatalf("accelerateRenameTable returned error: %v", err)
+	}
+
+	if len(d.tracker.dbs["`a`"].Tables) != 0 {
+		t.Errorf("table not removed from source database, Tables = %+v", d.tracker.dbs["`a`"].Tables)
+	}
+	bTables := d.tracker.dbs["`b`"].Tables
+	if len(bTables) != 1 || bTables[0].Name.O != "t2" {
+		t.Errorf("table not moved into destination database, Tables = %+v", bTables)
+	}
+}
@@ -0,0 +1,76 @@
+package pitr
+
+import (
+	"github.com/pingcap/errors"
+	"github.com/pingcap/parser/model"
+)
+
+// idAllocator is the subset of the embedded TiDB's meta access ShiftMetaToTiDB
+// needs to prealloc ids, mirroring meta.Meta.GenGlobalIDs: it reserves a
+// contiguous range of n ids the local TiDB has never handed out before.
+type idAllocator interface {
+	GenGlobalIDs(n int) ([]int64, error)
+}
+
+// preallocIDs implements BR's "prealloc DB" pattern: every DBInfo, TableInfo
+// (and partition) coming from the upstream cluster keeps whatever id it was
+// assigned there, which would collide with the ids the local TiDB in alloc
+// is about to hand out on its own for anything created after this reload.
+// It reserves one fresh id per schema object up front, rewrites every
+// DBInfo/TableInfo/PartitionDefinition in place to use it, and returns the
+// resulting old id -> new id map.
+//
+// ForeignKeyInfo isn't rewritten: TiDB resolves foreign keys by
+// (schema, table) name, not id, so a remapped table id doesn't invalidate
+// them. AutoIncID/AutoRandID aren't rewritten either - they're plain
+// counters carried on TableInfo itself, not values keyed by the table's old
+// id, so they stay correct under the table's new id without any change.
+func preallocIDs(alloc idAllocator, dbInfos []*model.DBInfo) (map[int64]int64, error) {
+	var n int
+	for _, db := range dbInfos {
+		n++
+		for _, t := range db.Tables {
+			n++
+			if t.Partition != nil {
+				n += len(t.Partition.Definitions)
+			}
+		}
+	}
+	if n == 0 {
+		return map[int64]int64{}, nil
+	}
+
+	newIDs, err := alloc.GenGlobalIDs(n)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(newIDs) != n {
+		return nil, errors.Errorf("GenGlobalIDs(%d) returned %d ids", n, len(newIDs))
+	}
+
+	idMap := make(map[int64]int64, n)
+	next := 0
+	takeID := func(oldID int64) int64 {
+		newID := newIDs[next]
+		next++
+		idMap[oldID] = newID
+		return newID
+	}
+
+	for _, db := range dbInfos {
+		db.ID = takeID(db.ID)
+
+		for _, t := range db.Tables {
+			t.ID = takeID(t.ID)
+
+			if t.Partition != nil {
+				for i := range t.Partition.Definitions {
+					def := &t.Partition.Definitions[i]
+					def.ID = takeID(def.ID)
+				}
+			}
+		}
+	}
+
+	return idMap, nil
+}
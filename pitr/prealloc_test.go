@@ -0,0 +1,140 @@
+package pitr
+
+import (
+	"testing"
+
+	"github.com/pingcap/parser/model"
+)
+
+// fakeAllocator hands out sequential ids starting just after next, mirroring
+// meta.Meta.GenGlobalIDs closely enough for preallocIDs' own bookkeeping to
+// be exercised without a real TiDB meta/txn.
+type fakeAllocator struct {
+	next int64
+}
+
+func (a *fakeAllocator) GenGlobalIDs(n int) ([]int64, error) {
+	ids := make([]int64, n)
+	for i := range ids {
+		a.next++
+		ids[i] = a.next
+	}
+	return ids, nil
+}
+
+func TestPreallocIDsPartitionedTable(t *testing.T) {
+	db := &model.DBInfo{
+		ID:   1,
+		Name: model.NewCIStr("test"),
+		Tables: []*model.TableInfo{
+			{
+				ID:   10,
+				Name: model.NewCIStr("t1"),
+				Partition: &model.PartitionInfo{
+					Definitions: []model.PartitionDefinition{
+						{ID: 100, Name: model.NewCIStr("p0")},
+						{ID: 101, Name: model.NewCIStr("p1")},
+					},
+				},
+			},
+		},
+	}
+
+	alloc := &fakeAllocator{}
+	idMap, err := preallocIDs(alloc, []*model.DBInfo{db})
+	if err != nil {
+		t.Fatalf("preallocIDs returned error: %v", err)
+	}
+
+	// one id each for the db, the table, and the two partitions.
+	if len(idMap) != 4 {
+		t.Fatalf("expected 4 remapped ids, got %d: %v", len(idMap), idMap)
+	}
+
+	wantNewID := func(oldID int64) int64 {
+		newID, ok := idMap[oldID]
+		if !ok {
+			t.Fatalf("no remapping recorded for old id %d", oldID)
+		}
+		return newID
+	}
+
+	if db.ID != wantNewID(1) {
+		t.Errorf("db.ID = %d, want %d", db.ID, wantNewID(1))
+	}
+	if db.Tables[0].ID != wantNewID(10) {
+		t.Errorf("table.ID = %d, want %d", db.Tables[0].ID, wantNewID(10))
+	}
+	defs := db.Tables[0].Partition.Definitions
+	if defs[0].ID != wantNewID(100) || defs[1].ID != wantNewID(101) {
+		t.Errorf("partition ids = %d, %d, want %d, %d", defs[0].ID, defs[1].ID, wantNewID(100), wantNewID(101))
+	}
+
+	// every remapped id must be distinct from each other.
+	seen := make(map[int64]bool)
+	for _, newID := range idMap {
+		if seen[newID] {
+			t.Errorf("new id %d handed out more than once", newID)
+		}
+		seen[newID] = true
+	}
+}
+
+func TestPreallocIDsLeavesForeignKeysAndAutoIDsAlone(t *testing.T) {
+	// a cross-db foreign key: t2 in db "b" references t1 in db "a" by name,
+	// not by id, so preallocIDs must leave ForeignKeyInfo untouched even
+	// though both tables' own ids get rewritten.
+	dbA := &model.DBInfo{
+		ID:   1,
+		Name: model.NewCIStr("a"),
+		Tables: []*model.TableInfo{
+			{ID: 10, Name: model.NewCIStr("t1")},
+		},
+	}
+	dbB := &model.DBInfo{
+		ID:   2,
+		Name: model.NewCIStr("b"),
+		Tables: []*model.TableInfo{
+			{
+				ID:        20,
+				Name:      model.NewCIStr("t2"),
+				AutoIncID: 42,
+				ForeignKeys: []*model.FKInfo{
+					{Name: model.NewCIStr("fk_t1"), RefTable: model.NewCIStr("t1"), RefCols: []model.CIStr{model.NewCIStr("id")}},
+				},
+			},
+		},
+	}
+
+	// start the fake id space above every old id, as a real local TiDB's
+	// would be, so "rewritten" can be told apart from "reused".
+	idMap, err := preallocIDs(&fakeAllocator{next: 1000}, []*model.DBInfo{dbA, dbB})
+	if err != nil {
+		t.Fatalf("preallocIDs returned error: %v", err)
+	}
+
+	if dbA.ID == 1 || dbB.Tables[0].ID == 20 {
+		t.Fatalf("expected ids to be rewritten, got dbA.ID=%d, t2.ID=%d", dbA.ID, dbB.Tables[0].ID)
+	}
+	if len(idMap) != 4 {
+		t.Fatalf("expected 4 remapped ids (2 dbs + 2 tables), got %d", len(idMap))
+	}
+
+	fk := dbB.Tables[0].ForeignKeys[0]
+	if fk.RefTable.O != "t1" {
+		t.Errorf("RefTable = %q, want unchanged %q", fk.RefTable.O, "t1")
+	}
+	if dbB.Tables[0].AutoIncID != 42 {
+		t.Errorf("AutoIncID = %d, want unchanged 42", dbB.Tables[0].AutoIncID)
+	}
+}
+
+func TestPreallocIDsEmpty(t *testing.T) {
+	idMap, err := preallocIDs(&fakeAllocator{}, nil)
+	if err != nil {
+		t.Fatalf("preallocIDs returned error: %v", err)
+	}
+	if len(idMap) != 0 {
+		t.Errorf("expected an empty id map, got %v", idMap)
+	}
+}
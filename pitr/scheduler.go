@@ -0,0 +1,178 @@
+package pitr
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/parser/model"
+)
+
+// ddlScheduler replays a batch of history ddl jobs with per-table
+// dependency scheduling: two jobs that touch disjoint (schema, table)s run
+// concurrently, while jobs touching the same table (or a cross-schema
+// barrier job) keep their original relative order.
+type ddlScheduler struct {
+	workers int
+	sem     chan struct{}
+
+	queueDepth     int64
+	curParallelism int64
+	maxParallelism int64
+
+	errsMu sync.Mutex
+	errs   []error
+}
+
+func newDDLScheduler(workers int) *ddlScheduler {
+	if workers <= 0 {
+		workers = 1
+	}
+	return &ddlScheduler{workers: workers, sem: make(chan struct{}, workers)}
+}
+
+// QueueDepth reports how many jobs are currently waiting on a dependency or
+// a free worker slot.
+func (sch *ddlScheduler) QueueDepth() int64 {
+	return atomic.LoadInt64(&sch.queueDepth)
+}
+
+// MaxParallelism reports the largest number of jobs this scheduler has ever
+// run at the same time.
+func (sch *ddlScheduler) MaxParallelism() int64 {
+	return atomic.LoadInt64(&sch.maxParallelism)
+}
+
+// run replays jobs in dependency order, calling apply for every job that
+// isn't filtered out by skip. Jobs with no dependency in common are handed
+// to the worker pool concurrently; apply is responsible for taking whatever
+// fine-grained locks it needs on the state it mutates. Returns the first
+// error encountered, if any, after every job has finished or been skipped.
+func (sch *ddlScheduler) run(jobs []*model.Job, skip func(*model.Job) bool, apply func(*model.Job) error) error {
+	// lastWriter tracks, for every dependency key, the done channel of the
+	// most recently enqueued job that touches it - mirrors TiDB's
+	// ddl_running_jobs "last job per table" bookkeeping.
+	lastWriter := make(map[string]chan struct{})
+	var lastBarrier chan struct{}
+	dones := make([]chan struct{}, 0, len(jobs))
+
+	for _, job := range jobs {
+		if skip(job) {
+			continue
+		}
+
+		keys, isBarrier := dependencyKeys(job)
+
+		var deps []chan struct{}
+		if lastBarrier != nil {
+			deps = append(deps, lastBarrier)
+		}
+		for _, k := range keys {
+			if ch, ok := lastWriter[k]; ok {
+				deps = append(deps, ch)
+			}
+		}
+
+		done := make(chan struct{})
+		dones = append(dones, done)
+
+		if isBarrier {
+			// a barrier has to wait for, and be waited on by, every
+			// table-scoped job already in flight.
+			for k, ch := range lastWriter {
+				deps = append(deps, ch)
+				delete(lastWriter, k)
+			}
+			lastBarrier = done
+		} else {
+			for _, k := range keys {
+				lastWriter[k] = done
+			}
+		}
+
+		atomic.AddInt64(&sch.queueDepth, 1)
+		go sch.runTask(job, deps, apply, done)
+	}
+
+	for _, done := range dones {
+		<-done
+	}
+
+	sch.errsMu.Lock()
+	defer sch.errsMu.Unlock()
+	if len(sch.errs) > 0 {
+		return sch.errs[0]
+	}
+	return nil
+}
+
+// runTask waits for deps, bounds concurrency to sch.workers, applies job and
+// records any error, then always closes done so dependants can proceed.
+func (sch *ddlScheduler) runTask(job *model.Job, deps []chan struct{}, apply func(*model.Job) error, done chan struct{}) {
+	defer close(done)
+
+	for _, dep := range deps {
+		<-dep
+	}
+
+	sch.sem <- struct{}{}
+	defer func() { <-sch.sem }()
+	atomic.AddInt64(&sch.queueDepth, -1)
+
+	cur := atomic.AddInt64(&sch.curParallelism, 1)
+	defer atomic.AddInt64(&sch.curParallelism, -1)
+	for {
+		max := atomic.LoadInt64(&sch.maxParallelism)
+		if cur <= max || atomic.CompareAndSwapInt64(&sch.maxParallelism, max, cur) {
+			break
+		}
+	}
+
+	if err := apply(job); err != nil {
+		sch.errsMu.Lock()
+		sch.errs = append(sch.errs, errors.Trace(err))
+		sch.errsMu.Unlock()
+	}
+}
+
+// dependencyKeys returns the (schema, table) keys a job touches, analogous
+// to TiDB's ddl_running_jobs dependency tracking. isBarrier is true for
+// cross-schema ddls (create/drop database, rename across schemas), which
+// must run fully serialized with respect to every other job.
+func dependencyKeys(job *model.Job) (keys []string, isBarrier bool) {
+	switch job.Type {
+	case model.ActionCreateSchema, model.ActionDropSchema, model.ActionModifySchemaCharsetAndCollate:
+		return nil, true
+	case model.ActionRenameTables:
+		// the atomic multi-table form can move each table into its own,
+		// independently named database (job.SchemaName is only the last
+		// table's new schema, not reliable for the rest), so there's no
+		// cheap way to name every key this job should hold the same way
+		// other jobs' (schema, table) keys are named; be conservative and
+		// serialize it against everything else instead.
+		return nil, true
+	case model.ActionRenameTable:
+		renames, err := decodeRenameTables(job)
+		if err != nil {
+			return nil, true
+		}
+		r := renames[0]
+		if r.oldSchemaID != job.SchemaID {
+			// the table moved to a different database: touches two schema
+			// entries at once, treat it like a barrier.
+			return nil, true
+		}
+		return []string{quoteDB(job.SchemaName) + "." + quoteDB(lowerName(r.newName.O))}, false
+	}
+
+	schema := quoteDB(job.SchemaName)
+	switch {
+	case job.BinlogInfo != nil && job.BinlogInfo.TableInfo != nil:
+		keys = append(keys, schema+"."+quoteDB(job.BinlogInfo.TableInfo.Name.L))
+	case len(job.TableName) != 0:
+		keys = append(keys, schema+"."+quoteDB(job.TableName))
+	default:
+		keys = append(keys, schema)
+	}
+	return keys, false
+}
@@ -0,0 +1,88 @@
+package pitr
+
+import (
+	"testing"
+
+	"github.com/pingcap/parser/model"
+)
+
+// TestSchedulerConcurrentSameSchemaRenameAndAlter drives an ADD COLUMN job on
+// one table and a same-schema RENAME TABLE job on a different table, both in
+// schema "s", through the real scheduler and applyAccelerateJob/
+// accelerateRenameTable - the same two code paths the non-barrier
+// same-schema-rename dependency key in dependencyKeys lets run concurrently.
+// Run with -race: before accelerateRenameTable took the database's dbLock
+// instead of the tracker-wide lock, this caught the two jobs mutating
+// DBInfo.Tables under two different locks.
+func TestSchedulerConcurrentSameSchemaRenameAndAlter(t *testing.T) {
+	d := &DDLHandle{tracker: newSchemaTracker()}
+	const schemaID = int64(1)
+	d.tracker.dbs["`s`"] = &model.DBInfo{
+		ID:   schemaID,
+		Name: model.NewCIStr("s"),
+		Tables: []*model.TableInfo{
+			{ID: 10, Name: model.NewCIStr("t_alter")},
+			{ID: 20, Name: model.NewCIStr("t_old")},
+		},
+	}
+
+	alterJob := &model.Job{
+		ID:         1,
+		Type:       model.ActionAddColumn,
+		State:      model.JobStateSynced,
+		SchemaName: "s",
+		TableName:  "t_alter",
+		BinlogInfo: &model.HistoryInfo{
+			TableInfo: &model.TableInfo{
+				ID:    10,
+				Name:  model.NewCIStr("t_alter"),
+				State: model.StatePublic,
+				Columns: []*model.ColumnInfo{
+					{Name: model.NewCIStr("c1")},
+				},
+			},
+		},
+	}
+
+	renameJob := &model.Job{
+		ID:         2,
+		Type:       model.ActionRenameTable,
+		State:      model.JobStateSynced,
+		SchemaID:   schemaID,
+		SchemaName: "s",
+		TableID:    20,
+		BinlogInfo: &model.HistoryInfo{
+			TableInfo: &model.TableInfo{ID: 20, Name: model.NewCIStr("t_new")},
+		},
+		Args: []interface{}{schemaID, model.NewCIStr("t_new")},
+	}
+	if _, err := renameJob.Encode(true); err != nil {
+		t.Fatalf("renameJob.Encode: %v", err)
+	}
+
+	sch := newDDLScheduler(4)
+	if err := sch.run([]*model.Job{alterJob, renameJob}, skipJob, d.AccelerateHistoryDDLs); err != nil {
+		t.Fatalf("scheduler run returned error: %v", err)
+	}
+
+	db := d.tracker.dbs["`s`"]
+	if len(db.Tables) != 2 {
+		t.Fatalf("expected 2 tables after run, got %d: %+v", len(db.Tables), db.Tables)
+	}
+
+	var gotAlter, gotRename bool
+	for _, tbl := range db.Tables {
+		switch tbl.ID {
+		case 10:
+			gotAlter = len(tbl.Columns) == 1 && tbl.Columns[0].Name.O == "c1"
+		case 20:
+			gotRename = tbl.Name.O == "t_new"
+		}
+	}
+	if !gotAlter {
+		t.Errorf("ADD COLUMN job's result not applied, Tables = %+v", db.Tables)
+	}
+	if !gotRename {
+		t.Errorf("RENAME TABLE job's result not applied, Tables = %+v", db.Tables)
+	}
+}
@@ -0,0 +1,118 @@
+package pitr
+
+import (
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/parser"
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/parser/mysql"
+)
+
+// parseStmts parses a (possibly multi-statement, e.g. "use db; alter ...")
+// ddl query into its AST nodes.
+func parseStmts(ddl string) ([]ast.StmtNode, error) {
+	stmts, _, err := parser.New().Parse(ddl, "", "")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return stmts, nil
+}
+
+func lowerName(name string) string {
+	return strings.ToLower(name)
+}
+
+// buildColumnInfo converts a parsed column definition into the
+// model.ColumnInfo the tracker keeps in TableInfo.Columns.
+func buildColumnInfo(col *ast.ColumnDef, offset int) *model.ColumnInfo {
+	ci := &model.ColumnInfo{
+		Name:      col.Name.Name,
+		Offset:    offset,
+		State:     model.StatePublic,
+		FieldType: *col.Tp,
+	}
+
+	for _, opt := range col.Options {
+		switch opt.Tp {
+		case ast.ColumnOptionNotNull:
+			ci.Flag |= mysql.NotNullFlag
+		case ast.ColumnOptionPrimaryKey:
+			ci.Flag |= mysql.PriKeyFlag | mysql.NotNullFlag
+		case ast.ColumnOptionUniqKey:
+			ci.Flag |= mysql.UniqueKeyFlag
+		case ast.ColumnOptionAutoIncrement:
+			ci.Flag |= mysql.AutoIncrementFlag | mysql.NotNullFlag
+		case ast.ColumnOptionGenerated:
+			// tableInfo only needs to know a column is generated (to exclude
+			// it from GetTableInfo's column list), not re-derive its
+			// expression, so a non-empty marker is enough here.
+			ci.GeneratedStored = opt.Stored
+			ci.GeneratedExprString = "generated"
+		}
+	}
+
+	return ci
+}
+
+// buildIndexInfo converts a parsed constraint (table-level in a CREATE
+// TABLE/ALTER TABLE ADD, or synthesized from a CREATE INDEX) into the
+// model.IndexInfo the tracker keeps in TableInfo.Indices. It returns a nil
+// index for constraints that don't describe an index (e.g. foreign keys),
+// which the caller should simply skip.
+func buildIndexInfo(table *model.TableInfo, cons *ast.Constraint, id int64) (*model.IndexInfo, error) {
+	var idxName string
+	var unique, primary bool
+
+	switch cons.Tp {
+	case ast.ConstraintPrimaryKey:
+		idxName, unique, primary = "PRIMARY", true, true
+	case ast.ConstraintUniq, ast.ConstraintUniqKey, ast.ConstraintUniqIndex:
+		idxName, unique = indexNameOrDefault(cons), true
+	case ast.ConstraintIndex, ast.ConstraintKey:
+		idxName = indexNameOrDefault(cons)
+	case ast.ConstraintForeignKey:
+		// a foreign key is not an index; nothing to add to Indices, and the
+		// tracker does not record foreign keys anywhere else either.
+		return nil, nil
+	default:
+		return nil, errors.Trace(errUnsupportedDDL)
+	}
+
+	idx := &model.IndexInfo{
+		ID:      id,
+		Name:    model.NewCIStr(idxName),
+		Table:   table.Name,
+		Unique:  unique,
+		Primary: primary,
+		State:   model.StatePublic,
+		Tp:      model.IndexTypeBtree,
+	}
+	for i, key := range cons.Keys {
+		idx.Columns = append(idx.Columns, &model.IndexColumn{
+			Name:   key.Column.Name,
+			Offset: i,
+			Length: key.Length,
+		})
+		if primary {
+			for _, c := range table.Columns {
+				if c.Name.L == key.Column.Name.L {
+					c.Flag |= mysql.PriKeyFlag
+				}
+			}
+		}
+	}
+
+	return idx, nil
+}
+
+func indexNameOrDefault(cons *ast.Constraint) string {
+	if len(cons.Name) != 0 {
+		return cons.Name
+	}
+	if len(cons.Keys) != 0 {
+		return cons.Keys[0].Column.Name.O
+	}
+	return "index"
+}
@@ -0,0 +1,452 @@
+package pitr
+
+import (
+	"sync"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/parser/mysql"
+)
+
+// errUnsupportedDDL is returned by schemaTracker.applyDDL when a statement
+// uses a feature the in-memory tracker cannot model itself. DDLHandle treats
+// this as a signal to fall back to a real TiDB instance for that one DDL.
+var errUnsupportedDDL = errors.New("ddl not supported by schema tracker")
+
+// affectedTable identifies one table whose TableInfo changed because of a
+// DDL statement.
+type affectedTable struct {
+	schema string
+	table  string
+}
+
+// schemaTracker keeps an in-memory, infoschema-like view of every database
+// and table, built purely by applying parsed DDL statements to a tree of
+// *model.DBInfo / *model.TableInfo. It plays the role tidb-lite used to
+// play for DDLHandle, without the cost of an embedded TiDB server.
+type schemaTracker struct {
+	sync.RWMutex
+
+	dbs    map[string]*model.DBInfo
+	nextID int64
+
+	// dbLocks holds one mutex per tracked database, so two jobs touching
+	// different databases (and, in turn, disjoint tables) can mutate
+	// lastDBInfoMap concurrently instead of serializing on a single lock.
+	dbLocks sync.Map
+}
+
+func newSchemaTracker() *schemaTracker {
+	return &schemaTracker{
+		dbs:    make(map[string]*model.DBInfo),
+		nextID: 1,
+	}
+}
+
+// lockDB returns the per-database mutex used to guard in-place mutation of
+// a single *model.DBInfo's Tables slice (e.g. from AccelerateHistoryDDLs).
+// It must be called while holding at least s.RLock() so the map entry it is
+// keyed on can't disappear underneath it.
+func (s *schemaTracker) lockDB(schema string) *sync.Mutex {
+	key := quoteDB(lowerName(schema))
+	v, _ := s.dbLocks.LoadOrStore(key, &sync.Mutex{})
+	return v.(*sync.Mutex)
+}
+
+func (s *schemaTracker) allocID() int64 {
+	id := s.nextID
+	s.nextID++
+	return id
+}
+
+// seedNextID raises nextID past the largest id already present in dbs.
+// Callers that load a whole dbs map in one shot - RestoreCheckpoint,
+// SetServerHistoryAccelerate - bypass allocID entirely and bring in ids from
+// a real upstream cluster or an earlier run's checkpoint, which are under no
+// obligation to be small; without this, a later applyDDL CREATE
+// TABLE/DATABASE could allocate an id that collides with one already
+// tracked, corrupting the by-id matching accelerateRenameTable and
+// applyAccelerateJob rely on. Must be called while holding s.Lock().
+func (s *schemaTracker) seedNextID() {
+	var maxID int64
+	for _, db := range s.dbs {
+		if db.ID > maxID {
+			maxID = db.ID
+		}
+		for _, t := range db.Tables {
+			if t.ID > maxID {
+				maxID = t.ID
+			}
+			if t.Partition != nil {
+				for _, p := range t.Partition.Definitions {
+					if p.ID > maxID {
+						maxID = p.ID
+					}
+				}
+			}
+			for _, idx := range t.Indices {
+				if idx.ID > maxID {
+					maxID = idx.ID
+				}
+			}
+		}
+	}
+	if maxID >= s.nextID {
+		s.nextID = maxID + 1
+	}
+}
+
+func (s *schemaTracker) getDB(schema string) (*model.DBInfo, bool) {
+	db, ok := s.dbs[quoteDB(lowerName(schema))]
+	return db, ok
+}
+
+// findDBByID scans the tracked schemas for the one with the given id. Rename
+// jobs identify the old/new schema by id rather than name (a table can move
+// to a database with any name), so callers that only have a schema id - e.g.
+// accelerateRenameTable - need this instead of getDB.
+func (s *schemaTracker) findDBByID(id int64) (*model.DBInfo, bool) {
+	for _, db := range s.dbs {
+		if db.ID == id {
+			return db, true
+		}
+	}
+	return nil, false
+}
+
+func (s *schemaTracker) getTable(schema, table string) (*model.TableInfo, bool) {
+	db, ok := s.getDB(schema)
+	if !ok {
+		return nil, false
+	}
+	for _, t := range db.Tables {
+		if t.Name.L == lowerName(table) {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+func (s *schemaTracker) setTable(schema string, table *model.TableInfo) {
+	db := s.dbs[quoteDB(lowerName(schema))]
+	for i, t := range db.Tables {
+		if t.ID == table.ID {
+			db.Tables[i] = table
+			return
+		}
+	}
+	db.Tables = append(db.Tables, table)
+}
+
+func (s *schemaTracker) dropTable(schema, table string) {
+	db, ok := s.getDB(schema)
+	if !ok {
+		return
+	}
+	for i, t := range db.Tables {
+		if t.Name.L == lowerName(table) {
+			db.Tables = append(db.Tables[:i], db.Tables[i+1:]...)
+			return
+		}
+	}
+}
+
+// applyDDL parses ddl and mutates the tracked schema tree in place,
+// returning every table the statement touched. It returns errUnsupportedDDL
+// for statements whose effect the tracker does not know how to model, so the
+// caller can fall back to a real TiDB for that single DDL.
+func (s *schemaTracker) applyDDL(schema, ddl string) ([]affectedTable, error) {
+	stmts, err := parseStmts(ddl)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	var affected []affectedTable
+	for _, stmt := range stmts {
+		switch node := stmt.(type) {
+		case *ast.UseStmt:
+			schema = node.DBName
+		case *ast.CreateDatabaseStmt:
+			s.applyCreateDatabase(node)
+		case *ast.DropDatabaseStmt:
+			delete(s.dbs, quoteDB(lowerName(node.Name)))
+		case *ast.CreateTableStmt:
+			sc := schemaOf(node.Table, schema)
+			if err := s.applyCreateTable(sc, node); err != nil {
+				return nil, errors.Trace(err)
+			}
+			affected = append(affected, affectedTable{sc, node.Table.Name.O})
+		case *ast.DropTableStmt:
+			for _, tn := range node.Tables {
+				sc := schemaOf(tn, schema)
+				s.dropTable(sc, tn.Name.O)
+				affected = append(affected, affectedTable{sc, tn.Name.O})
+			}
+		case *ast.AlterTableStmt:
+			sc := schemaOf(node.Table, schema)
+			if err := s.applyAlterTable(sc, node); err != nil {
+				return nil, errors.Trace(err)
+			}
+			affected = append(affected, affectedTable{sc, node.Table.Name.O})
+		case *ast.RenameTableStmt:
+			renamed, err := s.applyRenameTable(schema, node)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			affected = append(affected, renamed...)
+		case *ast.CreateIndexStmt:
+			sc := schemaOf(node.Table, schema)
+			if err := s.applyCreateIndex(sc, node); err != nil {
+				return nil, errors.Trace(err)
+			}
+			affected = append(affected, affectedTable{sc, node.Table.Name.O})
+		case *ast.DropIndexStmt:
+			sc := schemaOf(node.Table, schema)
+			if err := s.applyDropIndex(sc, node); err != nil {
+				return nil, errors.Trace(err)
+			}
+			affected = append(affected, affectedTable{sc, node.Table.Name.O})
+		case *ast.TruncateTableStmt:
+			sc := schemaOf(node.Table, schema)
+			if err := s.applyTruncateTable(sc, node); err != nil {
+				return nil, errors.Trace(err)
+			}
+			affected = append(affected, affectedTable{sc, node.Table.Name.O})
+		default:
+			return nil, errors.Trace(errUnsupportedDDL)
+		}
+	}
+
+	return affected, nil
+}
+
+func (s *schemaTracker) applyCreateDatabase(node *ast.CreateDatabaseStmt) {
+	if _, ok := s.getDB(node.Name); ok && node.IfNotExists {
+		return
+	}
+	s.dbs[quoteDB(lowerName(node.Name))] = &model.DBInfo{
+		ID:    s.allocID(),
+		Name:  model.NewCIStr(node.Name),
+		State: model.StatePublic,
+	}
+}
+
+func (s *schemaTracker) applyCreateTable(schema string, node *ast.CreateTableStmt) error {
+	db, ok := s.getDB(schema)
+	if !ok {
+		return errors.Errorf("database %s haven't exist before use it", schema)
+	}
+	if _, ok := s.getTable(schema, node.Table.Name.O); ok {
+		if node.IfNotExists {
+			return nil
+		}
+		return errors.Errorf("table %s already exists", node.Table.Name.O)
+	}
+
+	table := &model.TableInfo{
+		ID:    s.allocID(),
+		Name:  node.Table.Name,
+		State: model.StatePublic,
+	}
+	for i, col := range node.Cols {
+		table.Columns = append(table.Columns, buildColumnInfo(col, i))
+	}
+	for _, cons := range node.Constraints {
+		idx, err := buildIndexInfo(table, cons, s.allocID())
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if idx == nil {
+			continue
+		}
+		if cons.Tp == ast.ConstraintPrimaryKey && len(idx.Columns) == 1 {
+			table.PKIsHandle = isAutoIncrementInt(table, idx)
+		}
+		table.Indices = append(table.Indices, idx)
+	}
+
+	db.Tables = append(db.Tables, table)
+	return nil
+}
+
+func (s *schemaTracker) applyAlterTable(schema string, node *ast.AlterTableStmt) error {
+	table, ok := s.getTable(schema, node.Table.Name.O)
+	if !ok {
+		return errors.Errorf("table %s.%s haven't exist before alter it", schema, node.Table.Name.O)
+	}
+
+	for _, spec := range node.Specs {
+		switch spec.Tp {
+		case ast.AlterTableAddColumns:
+			for i, col := range spec.NewColumns {
+				table.Columns = append(table.Columns, buildColumnInfo(col, len(table.Columns)+i))
+			}
+		case ast.AlterTableDropColumn:
+			name := spec.OldColumnName.Name.L
+			for i, c := range table.Columns {
+				if c.Name.L == name {
+					table.Columns = append(table.Columns[:i], table.Columns[i+1:]...)
+					break
+				}
+			}
+		case ast.AlterTableModifyColumn, ast.AlterTableChangeColumn:
+			if len(spec.NewColumns) != 1 {
+				return errors.Trace(errUnsupportedDDL)
+			}
+			newCol := buildColumnInfo(spec.NewColumns[0], 0)
+			for i, c := range table.Columns {
+				if c.Name.L == spec.NewColumns[0].Name.Name.L || (spec.OldColumnName != nil && c.Name.L == spec.OldColumnName.Name.L) {
+					newCol.Offset = c.Offset
+					table.Columns[i] = newCol
+					break
+				}
+			}
+		case ast.AlterTableAddConstraint:
+			idx, err := buildIndexInfo(table, spec.Constraint, s.allocID())
+			if err != nil {
+				return errors.Trace(err)
+			}
+			if idx != nil {
+				table.Indices = append(table.Indices, idx)
+			}
+		case ast.AlterTableDropIndex:
+			dropIndexByName(table, spec.Name)
+		case ast.AlterTableDropPrimaryKey:
+			dropIndexByName(table, "PRIMARY")
+			table.PKIsHandle = false
+		case ast.AlterTableRenameTable:
+			table.Name = spec.NewTable.Name
+		case ast.AlterTableTableOptions:
+			// table options (charset, comment, auto_increment, ...) don't
+			// affect column/index layout, nothing to track.
+		default:
+			return errors.Trace(errUnsupportedDDL)
+		}
+	}
+
+	return nil
+}
+
+// applyRenameTable moves one or more tables between their old and new
+// (schema, table). TiDB parses the atomic `rename table a to b, c to d`
+// syntax as a single RenameTableStmt with one TableToTable pair per clause,
+// so a single statement can touch more tables than just node.OldTable/NewTable.
+func (s *schemaTracker) applyRenameTable(defaultSchema string, node *ast.RenameTableStmt) ([]affectedTable, error) {
+	pairs := node.TableToTables
+	if len(pairs) == 0 {
+		pairs = []*ast.TableToTable{{OldTable: node.OldTable, NewTable: node.NewTable}}
+	}
+
+	var affected []affectedTable
+	for _, pair := range pairs {
+		renamed, err := s.applyOneRenameTable(defaultSchema, pair.OldTable, pair.NewTable)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		affected = append(affected, renamed...)
+	}
+	return affected, nil
+}
+
+func (s *schemaTracker) applyOneRenameTable(defaultSchema string, oldTableName, newTableName *ast.TableName) ([]affectedTable, error) {
+	oldSchema := schemaOf(oldTableName, defaultSchema)
+	oldTable := oldTableName.Name.O
+	newSchema := schemaOf(newTableName, oldSchema)
+
+	table, ok := s.getTable(oldSchema, oldTable)
+	if !ok {
+		return nil, errors.Errorf("table %s.%s haven't exist before rename it", oldSchema, oldTable)
+	}
+	s.dropTable(oldSchema, oldTable)
+	table.Name = newTableName.Name
+	if _, ok := s.getDB(newSchema); !ok {
+		return nil, errors.Errorf("database %s haven't exist before use it", newSchema)
+	}
+	s.setTable(newSchema, table)
+
+	return []affectedTable{{oldSchema, oldTable}, {newSchema, newTableName.Name.O}}, nil
+}
+
+func (s *schemaTracker) applyCreateIndex(schema string, node *ast.CreateIndexStmt) error {
+	table, ok := s.getTable(schema, node.Table.Name.O)
+	if !ok {
+		return errors.Errorf("table %s.%s haven't exist before create index on it", schema, node.Table.Name.O)
+	}
+
+	cons := &ast.Constraint{
+		Tp:   ast.ConstraintIndex,
+		Name: node.IndexName,
+		Keys: node.IndexColNames,
+	}
+	if node.Unique {
+		cons.Tp = ast.ConstraintUniq
+	}
+	idx, err := buildIndexInfo(table, cons, s.allocID())
+	if err != nil {
+		return errors.Trace(err)
+	}
+	table.Indices = append(table.Indices, idx)
+	return nil
+}
+
+func (s *schemaTracker) applyDropIndex(schema string, node *ast.DropIndexStmt) error {
+	table, ok := s.getTable(schema, node.Table.Name.O)
+	if !ok {
+		return errors.Errorf("table %s.%s haven't exist before drop index on it", schema, node.Table.Name.O)
+	}
+	dropIndexByName(table, node.IndexName)
+	return nil
+}
+
+func (s *schemaTracker) applyTruncateTable(schema string, node *ast.TruncateTableStmt) error {
+	table, ok := s.getTable(schema, node.Table.Name.O)
+	if !ok {
+		return errors.Errorf("table %s.%s haven't exist before truncate it", schema, node.Table.Name.O)
+	}
+	table.ID = s.allocID()
+	return nil
+}
+
+func dropIndexByName(table *model.TableInfo, name string) {
+	lower := lowerName(name)
+	for i, idx := range table.Indices {
+		if idx.Name.L == lower {
+			table.Indices = append(table.Indices[:i], table.Indices[i+1:]...)
+			return
+		}
+	}
+}
+
+func isAutoIncrementInt(table *model.TableInfo, idx *model.IndexInfo) bool {
+	col := findColumn(table, idx.Columns[0].Name.O)
+	if col == nil {
+		return false
+	}
+	switch col.Tp {
+	case mysql.TypeLong, mysql.TypeLonglong, mysql.TypeInt24, mysql.TypeShort, mysql.TypeTiny:
+	default:
+		return false
+	}
+	return mysql.HasAutoIncrementFlag(col.Flag)
+}
+
+func findColumn(table *model.TableInfo, name string) *model.ColumnInfo {
+	lower := lowerName(name)
+	for _, c := range table.Columns {
+		if c.Name.L == lower {
+			return c
+		}
+	}
+	return nil
+}
+
+func schemaOf(table *ast.TableName, defaultSchema string) string {
+	if len(table.Schema.O) != 0 {
+		return table.Schema.O
+	}
+	return defaultSchema
+}
@@ -0,0 +1,93 @@
+package pitr
+
+import (
+	"testing"
+
+	"github.com/pingcap/parser/model"
+)
+
+func TestApplyDDLCreateAlterDropTable(t *testing.T) {
+	s := newSchemaTracker()
+
+	if _, err := s.applyDDL("", "create database d1"); err != nil {
+		t.Fatalf("create database: %v", err)
+	}
+	if _, err := s.applyDDL("d1", "create table t1 (a int, b int)"); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+
+	table, ok := s.getTable("d1", "t1")
+	if !ok {
+		t.Fatalf("t1 not tracked after create table")
+	}
+	if len(table.Columns) != 2 {
+		t.Fatalf("expected 2 columns after create, got %d", len(table.Columns))
+	}
+
+	if _, err := s.applyDDL("d1", "alter table t1 add column c int"); err != nil {
+		t.Fatalf("alter table add column: %v", err)
+	}
+	table, _ = s.getTable("d1", "t1")
+	if len(table.Columns) != 3 {
+		t.Fatalf("expected 3 columns after add column, got %d", len(table.Columns))
+	}
+
+	if _, err := s.applyDDL("d1", "alter table t1 drop column a"); err != nil {
+		t.Fatalf("alter table drop column: %v", err)
+	}
+	table, _ = s.getTable("d1", "t1")
+	if len(table.Columns) != 2 {
+		t.Fatalf("expected 2 columns after drop column, got %d", len(table.Columns))
+	}
+
+	if _, err := s.applyDDL("d1", "drop table t1"); err != nil {
+		t.Fatalf("drop table: %v", err)
+	}
+	if _, ok := s.getTable("d1", "t1"); ok {
+		t.Errorf("t1 still tracked after drop table")
+	}
+}
+
+func TestApplyDDLRenameTableAcrossSchemas(t *testing.T) {
+	s := newSchemaTracker()
+
+	for _, ddl := range []string{"create database d1", "create database d2", "create table d1.t1 (a int)"} {
+		if _, err := s.applyDDL("", ddl); err != nil {
+			t.Fatalf("%s: %v", ddl, err)
+		}
+	}
+
+	if _, err := s.applyDDL("", "rename table d1.t1 to d2.t2"); err != nil {
+		t.Fatalf("rename table: %v", err)
+	}
+
+	if _, ok := s.getTable("d1", "t1"); ok {
+		t.Errorf("t1 still tracked under d1 after cross-schema rename")
+	}
+	table, ok := s.getTable("d2", "t2")
+	if !ok {
+		t.Fatalf("t2 not tracked under d2 after cross-schema rename")
+	}
+	if len(table.Columns) != 1 {
+		t.Errorf("expected renamed table to keep its column, got %+v", table.Columns)
+	}
+}
+
+func TestSeedNextIDAvoidsCollisionAfterLoadingHighIDs(t *testing.T) {
+	s := newSchemaTracker()
+	// simulate RestoreCheckpoint/SetServerHistoryAccelerate loading a dbs map
+	// with large, real upstream-cluster ids instead of going through allocID.
+	s.dbs["`upstream`"] = &model.DBInfo{
+		ID:   1000,
+		Name: model.NewCIStr("upstream"),
+		Tables: []*model.TableInfo{
+			{ID: 1010, Name: model.NewCIStr("t1")},
+		},
+	}
+	s.seedNextID()
+
+	newID := s.allocID()
+	if newID <= 1010 {
+		t.Fatalf("allocID returned %d after seedNextID, expected something greater than the loaded max id 1010", newID)
+	}
+}
@@ -0,0 +1,15 @@
+package pitr
+
+import "fmt"
+
+// quoteDB returns the map key used to index lastDBInfoMap/schemaTracker.dbs
+// by (lower-cased) database name.
+func quoteDB(db string) string {
+	return fmt.Sprintf("`%s`", db)
+}
+
+// quoteSchema returns the map key used to index tableInfos by
+// (schema, table).
+func quoteSchema(schema, table string) string {
+	return fmt.Sprintf("`%s`.`%s`", schema, table)
+}